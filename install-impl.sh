@@ -14,15 +14,29 @@ Example: $PROGRAM_NAME -h
 Options:
   -h, --help                        Show this message and exit
   -v, --verbose                     Enable verbose output
+  --color=[when]                    Colorize output, one of 'auto', 'always' or 'never'. Defaults to auto, respecting NO_COLOR
+  -y, --yes                         Apply dotfiles without previewing and confirming the changes first
+  --transcript=[path]               Record the commands changing the system, with their input and exit codes, into the given file
   --ref=[git-ref]                   Reference the given git-ref for installation (can be any git ref - commit, branch, tag). Defaults to 'main'
+  --dotfiles-repo=[repo]            Install dotfiles from the given repo, either a GitHub username or a full git URL. Defaults to 'MrPointer'
+  --dotfiles-branch=[branch]        Install dotfiles from the given branch of the dotfiles repo. Defaults to the installation's git-ref, or the default branch of a custom repo
+  --full-name=[name]                Use the given full name in dotfiles. Defaults to git's 'user.name', otherwise prompted
+  --email=[email]                   Use given email address as personal email address. Defaults to git's 'user.email', otherwise prompted
   --work-env                        Treat this installation as a work environment
   --work-name                       Use the given work-name as the work environment. Defaults to 'sedg' (current workplace)
-  --work-email=[email]              Use given email address as work's email address. Defaults to 'timor.gruber@solaredge.com'
+  --work-email=[email]              Use given email address as work's email address. Defaults to git's 'user.email', otherwise prompted
+  --gpg-key-algorithm=[algorithm]   Algorithm of the GPG key to create, if one is created, e.g. 'ed25519' or 'rsa4096'. Defaults to ed25519
+  --gpg-key-expiry=[expiry]         Expiration of the GPG key to create, if one is created, e.g. '1y' or 'never'. Defaults to 2y
+  --gpg-passphrase-file=[path]      Create the GPG key non-interactively, protected by the passphrase in the given file (empty for none)
   --shell=[shell]                   Install given shell if required and set it as user's default. Defaults to zsh
   --brew-shell                      Install shell using brew. By default it's installed with system's package manager
   --no-brew                         Don't install brew (Homebrew)
+  --brew-cleanup                    Remove brew's cache and outdated downloads after installation, to save disk space
   --prefer-package-manager          Prefer installing tools with system's package manager rather than brew (Doesn't apply for Mac)
   --package-manager=[manager]       Package manager to use for installing prerequisites
+  --escalation-tool=[tool]          Tool to use for executing commands as root, one of 'sudo', 'doas' or 'run0'. Defaults to the first available
+  --containers                      Install a container runtime and configure the user to use it
+  --container-runtime=[runtime]     Container runtime to install, either 'docker' or 'podman'. Defaults to docker
 -----------------------------------------------------"
 DOTFILES_INSTALL_IMPL_USAGE
 }
@@ -36,6 +50,17 @@ YELLOW_COLOR="\033[1;33m"
 BLUE_COLOR="\033[0;34m"
 NEUTRAL_COLOR="\033[0m"
 
+###
+# Disable colorful prints by clearing all color codes.
+###
+function disable_colors {
+    RED_COLOR=""
+    GREEN_COLOR=""
+    YELLOW_COLOR=""
+    BLUE_COLOR=""
+    NEUTRAL_COLOR=""
+}
+
 function cecho {
     local string_placeholders=""
     for ((i = 1; i < $#; i++)); do
@@ -108,12 +133,127 @@ function root_user {
     ((current_uid == 0))
 }
 
+###
+# Execute given command, recording it along with its exit code into the transcript file (if any).
+# Recorded commands are shell-quoted, and their input (if given) is recorded as a heredoc,
+# so each of them can be replayed from the transcript.
+# Arguments:
+#       --input <content> - Optional, content to feed the command's standard input with
+#       $1..$N - Command to execute, followed by its arguments
+# Returns:
+#       Command's result, zero on success.
+###
+function run_recorded {
+    local input=""
+    local has_input=false
+    if [[ "${1:-}" == "--input" ]]; then
+        input="${2?}"
+        has_input=true
+        shift 2
+    fi
+
+    local rc
+    if [[ "$has_input" == true ]]; then
+        "$@" <<<"$input"
+    else
+        "$@"
+    fi
+    rc=$?
+
+    [[ -z "$TRANSCRIPT_PATH" ]] && return $rc
+
+    {
+        printf "%q " "$@"
+        if [[ "$has_input" == true ]]; then
+            printf "<<'EOF' # exit code: %d\n" "$rc"
+            printf "%s\n" "$input" "EOF"
+        else
+            printf "# exit code: %d\n" "$rc"
+        fi
+    } >>"$TRANSCRIPT_PATH"
+    return $rc
+}
+
+###
+# Execute given command with root privileges, using the selected escalation tool.
+# If the current user is already root, the command is executed as is.
+# Arguments:
+#       --input <content> - Optional, content to feed the command's standard input with
+#       $1..$N - Command to execute, followed by its arguments
+# Returns:
+#       Command's result, zero on success.
+###
+function run_privileged {
+    local record_options=()
+    if [[ "${1:-}" == "--input" ]]; then
+        record_options=("$1" "${2?}")
+        shift 2
+    fi
+
+    if [[ "$ROOT_USER" == true ]]; then
+        run_recorded "${record_options[@]}" "$@"
+        return
+    fi
+
+    if [[ -z "$ESCALATION_TOOL" ]]; then
+        error "No privilege escalation tool available, can't execute '$1' as root"
+        return 1
+    fi
+
+    run_recorded "${record_options[@]}" "$ESCALATION_TOOL" "$@"
+}
+
+function _stop_credentials_refresh {
+    [[ -n "$CREDENTIALS_REFRESH_PID" ]] && kill "$CREDENTIALS_REFRESH_PID" &>/dev/null
+}
+
+###
+# Ask for root credentials once, up front, and keep them cached throughout the installation,
+# so the user isn't prompted again in the middle of long running steps (e.g. installing brew).
+# Only sudo supports refreshing its cached credentials, other escalation tools are left as is.
+###
+function cache_privilege_credentials {
+    [[ "$ROOT_USER" == true || "$ESCALATION_TOOL" != "sudo" ]] && return 0
+
+    sudo -v || return 1
+
+    # Keep refreshing cached credentials in the background, as long as the installation runs
+    while kill -0 "$$" &>/dev/null; do
+        sleep "$CREDENTIALS_REFRESH_INTERVAL"
+        sudo -n -v &>/dev/null || break
+    done &
+    CREDENTIALS_REFRESH_PID=$!
+
+    trap _stop_credentials_refresh EXIT
+}
+
 function _install_packages_with_brew {
     local packages=("$@")
 
     install_package_cmd=(brew install --force-bottle "${packages[@]}")
 
-    "${install_package_cmd[@]}"
+    run_recorded "${install_package_cmd[@]}"
+}
+
+###
+# Refresh package manager's metadata cache, at most once per installation.
+# Fresh systems (especially containers) usually don't have any metadata cached, failing every install.
+###
+function _refresh_package_manager_cache {
+    [[ "$PACKAGE_MANAGER_CACHE_REFRESHED" == true ]] && return 0
+
+    case "$PACKAGE_MANAGER" in
+    apt)
+        run_privileged apt-get update || return 1
+        ;;
+    dnf)
+        run_privileged dnf makecache || return 1
+        ;;
+    *) ;;
+    esac
+
+    PACKAGE_MANAGER_CACHE_REFRESHED=true
+    return 0
 }
 
 function _install_packages_with_package_manager {
@@ -124,11 +264,12 @@ function _install_packages_with_package_manager {
         return 1
     fi
 
-    local install_package_cmd=()
-    if [[ "$ROOT_USER" == false ]]; then
-        install_package_cmd=(sudo)
+    if ! _refresh_package_manager_cache; then
+        error "Failed refreshing $PACKAGE_MANAGER's cache"
+        return 2
     fi
-    install_package_cmd+=("$PACKAGE_MANAGER" install -y "${packages[@]}")
+
+    local install_package_cmd=(run_privileged "$PACKAGE_MANAGER" install -y "${packages[@]}")
 
     "${install_package_cmd[@]}"
 }
@@ -185,6 +326,66 @@ function _reinstall_chezmoi_as_package {
     return 0
 }
 
+function _cleanup_brew {
+    [[ "$INSTALL_BREW" == false || "$CLEANUP_BREW" == false ]] && return 0
+
+    [ "$VERBOSE" == true ] && info "Cleaning up brew's cache"
+    run_recorded brew cleanup --prune=all
+}
+
+###
+# Verify git has been configured to sign commits with the selected GPG key.
+# Git's config is a managed dotfile, so a mismatch is only reported - fixing it here would just be overridden.
+###
+function _verify_git_signing_config {
+    ! hash git &>/dev/null && return 0
+
+    local configured_signing_key
+    configured_signing_key="$(git config --global --get user.signingkey)"
+    if [[ "$configured_signing_key" != "$ACTIVE_GPG_SIGNING_KEY" ]]; then
+        error "git's signing key is '$configured_signing_key' rather than '$ACTIVE_GPG_SIGNING_KEY'"
+        return 1
+    fi
+
+    if [[ "$(git config --global --type=bool --get commit.gpgsign)" != true ]]; then
+        error "git isn't configured to sign commits"
+        return 2
+    fi
+
+    return 0
+}
+
+###
+# Restrict permissions of sensitive directories (e.g. ~/.ssh, ~/.gnupg) to the current user only,
+# as both ssh and gpg refuse to use (or warn about) keys with loose permissions.
+# Public keys are kept readable by everyone, as they're meant to be.
+# Only files owned by the current user are touched, the rest can't be changed without root anyway.
+# Returns:
+#       0 if all directories have been hardened, 1 if any of them failed.
+###
+function harden_sensitive_permissions {
+    local hardening_failed=false
+    local sensitive_dir
+    for sensitive_dir in "${SENSITIVE_DIRS[@]}"; do
+        [[ -d "$sensitive_dir" ]] || continue
+
+        # Fixing ownership requires root, and is usually a sign of a bigger problem, so only report it
+        if [[ -n "$(find "$sensitive_dir" ! -user "$CURRENT_USER_NAME" -print -quit)" ]]; then
+            warning "Some files under $sensitive_dir aren't owned by $CURRENT_USER_NAME, please fix them manually"
+        fi
+
+        # Keep going on failures, so other directories are still hardened
+        run_recorded find "$sensitive_dir" -user "$CURRENT_USER_NAME" -type d -exec chmod 700 {} + ||
+            hardening_failed=true
+        run_recorded find "$sensitive_dir" -user "$CURRENT_USER_NAME" -type f ! -name "*.pub" -exec chmod 600 {} + ||
+            hardening_failed=true
+        run_recorded find "$sensitive_dir" -user "$CURRENT_USER_NAME" -type f -name "*.pub" -exec chmod 644 {} + ||
+            hardening_failed=true
+    done
+
+    [[ "$hardening_failed" == false ]]
+}
+
 ###
 # Finalize installation by executing post-install commands.
 ###
@@ -194,6 +395,19 @@ function post_install {
         # It's not a fatal error, we can proceed
     fi
 
+    if ! _cleanup_brew; then
+        warning "Failed cleaning up brew's cache"
+    fi
+
+    # git's config is one of the dotfiles, so there's nothing to verify if they weren't applied
+    if [[ "$DOTFILES_APPLIED" == true ]] && ! _verify_git_signing_config; then
+        warning "git commits won't be signed as expected, please check your git config"
+    fi
+
+    if ! harden_sensitive_permissions; then
+        warning "Failed restricting permissions of sensitive directories, ssh and gpg might refuse using your keys"
+    fi
+
     if [[ "$SHELL_TO_INSTALL" == "bash" ]]; then
         if ! _reload_shell_user_profile; then
             warning "Failed reloading shell profile, please attempt a manual re-login"
@@ -205,69 +419,144 @@ function post_install {
     return 0
 }
 
+###
+# Show the changes the dotfiles manager is about to make, and ask the user whether to apply them.
+# Returns:
+#       0 if the user has confirmed the changes, 1 otherwise.
+###
+function _confirm_dotfiles_changes {
+    # Externals (e.g. oh-my-zsh) would bury the actual dotfiles changes
+    "$DOTFILES_MANAGER_CMD" diff --exclude=externals
+
+    info "Would you like to apply these changes?"
+    local answer
+    select answer in "Yes" "No"; do
+        case $answer in
+        [Yy]*)
+            return 0
+            ;;
+        [Nn]*)
+            return 1
+            ;;
+        esac
+    done
+}
+
 ###
 # Apply dotfiles, optionally by using a dotfiles manager.
+# Unless asked not to, the changes are previewed and confirmed by the user before being applied.
+# Declining the changes isn't a failure, dotfiles are simply left unapplied.
 ###
 function apply_dotfiles {
     # Always remove old dotfiles, if any, just in case
-    rm -rf "$DOTFILES_CLONE_PATH" || return 1
+    run_recorded rm -rf "$DOTFILES_CLONE_PATH" || return 1
+
+    run_recorded "${INIT_DOTFILES_CMD[@]}" || return 2
+
+    # Nobody can confirm anything when not running interactively
+    if [[ "$ASSUME_YES" == false && -t 0 ]]; then
+        if ! _confirm_dotfiles_changes; then
+            warning "Changes were declined, run '$DOTFILES_MANAGER apply' when ready"
+            return 0
+        fi
+    fi
 
-    "${APPLY_DOTFILES_CMD[@]}"
+    run_recorded "$DOTFILES_MANAGER_CMD" apply || return 3
+    DOTFILES_APPLIED=true
 }
 
 ###
-# Prepare dotfiles environment before applying dotfiles.
-# This might be a useful step for some dotfiles managers.
+# Write standard input into the given file atomically.
+# Content is first written to a temporary file next to the given one, then moved in place,
+# so that a failure never leaves the file partially written.
+# Arguments:
+#       $1 - Path of the file to write
 ###
-function prepare_dotfiles_environment {
-    if ! mkdir -p "$ENVIRONMENT_TEMPLATE_CONFIG_DIR" &>/dev/null; then
-        error "Couldn't create environment's dotfiles config directory"
-        return 1
-    fi
+function write_file_atomically {
+    local file_path="${1:?}"
+
+    local tmp_file_path
+    tmp_file_path="$(mktemp "${file_path}.XXXXXX")" || return 1
 
-    # The first print zeroes the template file if it already has content
-    if ! printf "%s\n" "[data]" >"$ENVIRONMENT_TEMPLATE_FILE_PATH"; then
-        error "Failed initializing environment template file!"
+    if ! cat >"$tmp_file_path" || ! mv -f "$tmp_file_path" "$file_path"; then
+        rm -f "$tmp_file_path"
         return 2
     fi
+}
 
-    {
-        printf "%s\n" "[data.personal]"
-        printf "\t%s\n" "full_name = \"$FULL_NAME\""
-        printf "\t%s\n" "email = \"$ACTIVE_EMAIL\""
-        printf "\t%s\n" "signing_key = \"$ACTIVE_GPG_SIGNING_KEY\""
-        printf "\t%s\n" "work_env = $WORK_ENVIRONMENT"
-    } >>"$ENVIRONMENT_TEMPLATE_FILE_PATH"
+###
+# Escape given string to be used as a TOML basic string, i.e. between double quotes.
+# Arguments:
+#       $1 - String to escape
+# Output (stdout):
+#       Escaped string, without surrounding quotes
+###
+function _escape_toml_string {
+    local escaped="${1//\\/\\\\}"
+    printf "%s" "${escaped//\"/\\\"}"
+}
+
+###
+# Print the environment's template file, holding all data required by the managed dotfiles.
+###
+function _print_environment_template {
+    printf "%s\n" "[data]"
+
+    printf "%s\n" "[data.personal]"
+    printf "\t%s\n" "full_name = \"$(_escape_toml_string "$FULL_NAME")\""
+    printf "\t%s\n" "email = \"$(_escape_toml_string "$ACTIVE_EMAIL")\""
+    printf "\t%s\n" "signing_key = \"$ACTIVE_GPG_SIGNING_KEY\""
+    printf "\t%s\n" "work_env = $WORK_ENVIRONMENT"
 
     if [[ "$WORK_ENVIRONMENT" == true ]]; then
-        printf "\t%s\n" "work_name = \"$WORK_NAME\"" >>"$ENVIRONMENT_TEMPLATE_FILE_PATH"
+        printf "\t%s\n" "work_name = \"$(_escape_toml_string "$WORK_NAME")\""
     fi
 
-    {
-        printf "%s\n" "[data.system]"
-        printf "\t%s\n" "shell = \"$SHELL_TO_INSTALL\""
-        printf "\t%s\n" "user = \"$CURRENT_USER_NAME\""
-    } >>"$ENVIRONMENT_TEMPLATE_FILE_PATH"
+    printf "%s\n" "[data.system]"
+    printf "\t%s\n" "shell = \"$SHELL_TO_INSTALL\""
+    printf "\t%s\n" "user = \"$CURRENT_USER_NAME\""
 
     if [[ "$WORK_ENVIRONMENT" == true ]]; then
-        {
-            printf "\t%s\n" "work_generic_dotfiles_dir = \"${WORK_GENERIC_DOTFILES_DIR}\""
-            printf "\t%s\n" "work_specific_dotfiles_dir = \"${WORK_SPECIFIC_DOTFILES_DIR}\""
-            printf "\t%s\n" "work_generic_dotfiles_profile = \"${WORK_GENERIC_DOTFILES_PROFILE}\""
-            printf "\t%s\n" "work_specific_dotfiles_profile = \"${WORK_SPECIFIC_DOTFILES_PROFILE}\""
-        } >>"$ENVIRONMENT_TEMPLATE_FILE_PATH"
+        printf "\t%s\n" "work_generic_dotfiles_dir = \"${WORK_GENERIC_DOTFILES_DIR}\""
+        printf "\t%s\n" "work_specific_dotfiles_dir = \"${WORK_SPECIFIC_DOTFILES_DIR}\""
+        printf "\t%s\n" "work_generic_dotfiles_profile = \"${WORK_GENERIC_DOTFILES_PROFILE}\""
+        printf "\t%s\n" "work_specific_dotfiles_profile = \"${WORK_SPECIFIC_DOTFILES_PROFILE}\""
     fi
 
-    {
-        printf "%s\n" "[data.tools_preferences]"
-        printf "\t%s\n" "prefer_brew = $PREFER_BREW_FOR_ALL_TOOLS"
-    } >>"$ENVIRONMENT_TEMPLATE_FILE_PATH"
+    printf "%s\n" "[data.tools_preferences]"
+    printf "\t%s\n" "prefer_brew = $PREFER_BREW_FOR_ALL_TOOLS"
+}
+
+###
+# Prepare dotfiles environment before applying dotfiles.
+# This might be a useful step for some dotfiles managers.
+###
+function prepare_dotfiles_environment {
+    if ! mkdir -p "$ENVIRONMENT_TEMPLATE_CONFIG_DIR" &>/dev/null; then
+        error "Couldn't create environment's dotfiles config directory"
+        return 1
+    fi
+
+    # Any previous content of the template file is overwritten
+    if ! _print_environment_template | write_file_atomically "$ENVIRONMENT_TEMPLATE_FILE_PATH"; then
+        error "Failed writing environment template file!"
+        return 2
+    fi
 }
 
 function _create_new_gpg_key {
     declare -n created_key="${1:?}"
 
-    gpg --expert --full-gen-key || return 1
+    local create_key_cmd=(gpg)
+    if [[ -n "$GPG_PASSPHRASE_FILE" ]]; then
+        # Don't prompt for anything, e.g. on machines provisioned non-interactively
+        create_key_cmd+=(--batch --pinentry-mode loopback --passphrase-file "$GPG_PASSPHRASE_FILE")
+        # Matching keys are reused beforehand, so a key with the same user id is really wanted here
+        create_key_cmd+=(--yes)
+    fi
+    create_key_cmd+=(--quick-generate-key "$FULL_NAME <$ACTIVE_EMAIL>" "$GPG_KEY_ALGORITHM" sign "$GPG_KEY_EXPIRY")
+
+    run_recorded "${create_key_cmd[@]}" || return 1
     created_key="$(gpg --list-secret-keys --keyid-format LONG | tr -s " " | awk -F"[ /]" '/^sec/ { print $3 }' | tail -n1)" || return 2
     return 0
 }
@@ -297,7 +586,7 @@ function _install_gpg_client {
     ((rc == 2)) && return 1
 
     info "Installing gpg"
-    if ! sudo apt-get update && sudo apt-get install -y --no-install-recommends gpg; then
+    if ! _refresh_package_manager_cache || ! run_privileged apt-get install -y --no-install-recommends gpg; then
         error "Failed installing gpg using apt"
         return 2
     fi
@@ -305,12 +594,80 @@ function _install_gpg_client {
     _verify_gpg_client_installation
 }
 
+function _format_gpg_key_expiration {
+    local expiration="${1:-}"
+
+    if [[ -z "$expiration" ]]; then
+        echo "never expires"
+        return 0
+    fi
+
+    # GNU date and BSD date (Mac) take epoch timestamps differently
+    echo "expires $(date -d "@$expiration" +%F 2>/dev/null || date -r "$expiration" +%F)"
+}
+
+###
+# List available secret GPG keys, along with a human-readable description of each,
+# made of the key's primary user id (name and email) and its expiration date.
+# Arguments:
+#       $1 - Name of an array to store key IDs in
+#       $2 - Name of an array to store key descriptions in, matching key IDs by index
+###
+function _list_available_gpg_keys {
+    declare -n listed_keys="${1:?}"
+    declare -n listed_key_descriptions="${2:?}"
+
+    local record_type key_id expiration user_id
+    local key_expiration=""
+    while IFS=: read -r record_type _ _ _ key_id _ expiration _ _ user_id _; do
+        case "$record_type" in
+        sec)
+            listed_keys+=("$key_id")
+            key_expiration="$(_format_gpg_key_expiration "$expiration")"
+            ;;
+        uid)
+            # Only the first user id of each key is its primary one
+            if ((${#listed_key_descriptions[@]} < ${#listed_keys[@]})); then
+                listed_key_descriptions+=("${listed_keys[-1]} - $user_id ($key_expiration)")
+            fi
+            ;;
+        esac
+    done < <(gpg --list-secret-keys --with-colons)
+}
+
+###
+# Find an available secret GPG key whose primary user id has the given email.
+# Arguments:
+#       $1 - Name of the variable to store the found key's ID in
+#       $2 - Email to look for
+# Returns:
+#       0 if a matching key has been found, 1 otherwise.
+###
+function _find_gpg_key_by_email {
+    declare -n found_key="${1:?}"
+    local email="${2:?}"
+
+    local available_keys=()
+    local available_key_descriptions=()
+    _list_available_gpg_keys available_keys available_key_descriptions
+
+    local i
+    for i in "${!available_key_descriptions[@]}"; do
+        if [[ "${available_key_descriptions[i]}" == *"<$email>"* ]]; then
+            found_key="${available_keys[i]}"
+            return 0
+        fi
+    done
+    return 1
+}
+
 ###
 # Ensures a GPG key exist in order to be able to sign git commits in the future (and maybe do other stuff).
 # If a key is not already available, a new one is created instead and will be used in all managed dotfiles.
 # Otherwise, the user is asked whether to reuse an existing key, and if so which one.
 # The user can also decide to create a new one nevertheless.
-# The script requires some interactivity.
+# When running unattended (with a GPG passphrase file, or without a terminal), nothing is asked:
+# the key matching the active email is reused if available, otherwise a new one is created.
 ###
 function ensure_gpg_key_exist {
     info "Installing gpg client (if required)"
@@ -323,28 +680,43 @@ function ensure_gpg_key_exist {
     if gpg --list-secret-keys --keyid-format LONG | grep -q "sec"; then
         info "GPG keys already available"
 
-        info "Would you like to reuse one of the available keys?"
-        local answer
-        select answer in "Yes" "No"; do
-            case $answer in
-            [Yy]*)
-                local available_keys
-                mapfile -t available_keys < <(gpg --list-secret-keys --keyid-format LONG | tr -s " " | awk -F"[ /]" '/^sec/ { print $3 }')
-
-                info "Select the key to reuse:"
-                local selected_key
-                select selected_key in "${available_keys[@]}"; do
-                    warning "Using $selected_key as the GPG key"
-                    ACTIVE_GPG_SIGNING_KEY="$selected_key"
-                    return 0
-                done
-                ;;
-            [Nn]*)
-                warning "Creating a new GPG key"
-                break
-                ;;
-            esac
-        done
+        if [[ -n "$GPG_PASSPHRASE_FILE" || ! -t 0 ]]; then
+            # Nobody to ask when running unattended, reuse the key matching the active email (if any)
+            local matching_key
+            if _find_gpg_key_by_email matching_key "$ACTIVE_EMAIL"; then
+                warning "Using $matching_key as the GPG key, matching $ACTIVE_EMAIL"
+                ACTIVE_GPG_SIGNING_KEY="$matching_key"
+                return 0
+            fi
+            warning "None of the available keys matches $ACTIVE_EMAIL, creating a new GPG key"
+        else
+            info "Would you like to reuse one of the available keys?"
+            local answer
+            select answer in "Yes" "No"; do
+                case $answer in
+                [Yy]*)
+                    local available_keys=()
+                    local available_key_descriptions=()
+                    _list_available_gpg_keys available_keys available_key_descriptions
+
+                    info "Select the key to reuse:"
+                    local selected_key_description
+                    select selected_key_description in "${available_key_descriptions[@]}"; do
+                        [[ -z "$selected_key_description" ]] && continue
+
+                        local selected_key="${available_keys[REPLY - 1]}"
+                        warning "Using $selected_key as the GPG key"
+                        ACTIVE_GPG_SIGNING_KEY="$selected_key"
+                        return 0
+                    done
+                    ;;
+                [Nn]*)
+                    warning "Creating a new GPG key"
+                    break
+                    ;;
+                esac
+            done
+        fi
     fi
 
     local new_gpg_key
@@ -358,6 +730,100 @@ function ensure_gpg_key_exist {
     return 0
 }
 
+###
+# Prompt the user for a value, until a non-empty one is given.
+# Arguments:
+#       $1 - Name of the variable to store the value in
+#       $2 - Prompt to show the user
+# Returns:
+#       0 on success, 1 if not running interactively or input has been closed
+###
+function _prompt_for_value {
+    declare -n prompted_value="${1:?}"
+    local prompt="${2:?}"
+
+    # Nobody can answer when not running interactively
+    [[ ! -t 0 ]] && return 1
+
+    while [[ -z "$prompted_value" ]]; do
+        read -r -p "$prompt: " prompted_value || return 1
+    done
+}
+
+function _get_git_config_value {
+    ! hash git &>/dev/null && return 1
+
+    git config --global --get "${1:?}"
+}
+
+###
+# Resolve user's identity (name and email), used throughout the dotfiles, e.g. by git.
+# Values given as options take precedence, then values from existing git config,
+# and as a last resort the user is prompted for them.
+###
+function resolve_identity {
+    if [[ -z "$FULL_NAME" ]]; then
+        FULL_NAME="$(_get_git_config_value user.name)"
+    fi
+    if [[ -z "$FULL_NAME" ]] && ! _prompt_for_value FULL_NAME "Full name"; then
+        error "Full name is required, please pass it with --full-name"
+        return 1
+    fi
+
+    if [[ "$WORK_ENVIRONMENT" == true ]]; then
+        ACTIVE_EMAIL="$WORK_EMAIL"
+    else
+        ACTIVE_EMAIL="$PERSONAL_EMAIL"
+    fi
+    if [[ -z "$ACTIVE_EMAIL" ]]; then
+        ACTIVE_EMAIL="$(_get_git_config_value user.email)"
+    fi
+    if [[ -z "$ACTIVE_EMAIL" ]] && ! _prompt_for_value ACTIVE_EMAIL "Email"; then
+        error "Email is required, please pass it with --email (or --work-email for work environments)"
+        return 2
+    fi
+
+    return 0
+}
+
+###
+# Checks whether the given shell is listed as a valid login shell.
+# Arguments:
+#       $1 - Full path of the shell to check
+# Returns:
+#       0 if the shell is listed, 1 otherwise.
+###
+function etc_shells_contains {
+    grep -qxF "${1:?}" "$ETC_SHELLS_FILE" 2>/dev/null
+}
+
+###
+# Add given shell to the list of valid login shells, if not already listed.
+# Shells installed outside of system's package manager (e.g. with brew) aren't listed by default,
+# and can't be set as user's default shell until they are.
+# The list is first written next to the original, then moved in place, to never leave it partially written.
+# Arguments:
+#       $1 - Full path of the shell to add
+###
+function etc_shells_add {
+    local shell_path="${1:?}"
+
+    etc_shells_contains "$shell_path" && return 0
+
+    local new_etc_shells_file="${ETC_SHELLS_FILE}.new"
+    local new_etc_shells
+    new_etc_shells="$(
+        [[ -f "$ETC_SHELLS_FILE" ]] && cat "$ETC_SHELLS_FILE"
+        echo "$shell_path"
+    )" || return 1
+
+    # Content is passed explicitly (rather than piped), so it's recorded in the transcript as well
+    run_privileged --input "$new_etc_shells" tee "$new_etc_shells_file" >/dev/null || return 1
+
+    run_privileged chmod 644 "$new_etc_shells_file" || return 2
+    run_privileged mv -f "$new_etc_shells_file" "$ETC_SHELLS_FILE"
+}
+
 ###
 # Install selected shell using either system's package manager or homebrew, depending on the passed options.
 # If selected shell is already installed, do nothing.
@@ -381,8 +847,128 @@ function install_shell {
     local shell_path
     shell_path="$(which "$SHELL_TO_INSTALL")"
 
+    if ! etc_shells_add "$shell_path"; then
+        error "Failed adding $shell_path to $ETC_SHELLS_FILE"
+        return 3
+    fi
+
     # Then configure it as user's default shell
-    sudo chsh -s "$shell_path" "$CURRENT_USER_NAME"
+    run_privileged chsh -s "$shell_path" "$CURRENT_USER_NAME"
+}
+
+function _get_docker_package_name {
+    case "$PACKAGE_MANAGER" in
+    apt)
+        echo "docker.io"
+        ;;
+    dnf)
+        echo "moby-engine"
+        ;;
+    *)
+        return 1
+        ;;
+    esac
+}
+
+function _systemd_available {
+    hash systemctl &>/dev/null && [[ -d /run/systemd/system ]]
+}
+
+function _configure_docker {
+    # Allow using docker without root privileges, applies on next login
+    run_privileged usermod -aG docker "$CURRENT_USER_NAME" || return 1
+
+    if ! _systemd_available; then
+        warning "systemd isn't available, please start the docker daemon manually"
+        return 0
+    fi
+    run_privileged systemctl enable --now docker
+}
+
+###
+# Find the first range of subordinate ids following all ranges already allocated to any user,
+# so the new range never overlaps another user's (which would let their containers reach each other's files).
+# Ranges are sized and started just like useradd allocates them.
+# Output (stdout):
+#       Range in the format expected by usermod, e.g. "165536-231071"
+###
+function _get_next_free_subid_range {
+    local subid_files=()
+    local subid_file
+    for subid_file in "$ETC_SUBUID_FILE" "$ETC_SUBGID_FILE"; do
+        [[ -f "$subid_file" ]] && subid_files+=("$subid_file")
+    done
+
+    local range_start="$SUBID_RANGE_MIN_START"
+    if ((${#subid_files[@]} > 0)); then
+        local allocated_end
+        allocated_end="$(awk -F: '{ end = $2 + $3; if (end > max) max = end } END { print max + 0 }' "${subid_files[@]}")" || return 1
+        ((allocated_end > range_start)) && range_start="$allocated_end"
+    fi
+
+    echo "${range_start}-$((range_start + SUBID_RANGE_SIZE - 1))"
+}
+
+function _configure_rootless_podman {
+    # Rootless podman requires subordinate uid/gid ranges for the user
+    local subid_range
+    subid_range="$(_get_next_free_subid_range)" || return 1
+
+    if ! grep -q "^${CURRENT_USER_NAME}:" "$ETC_SUBUID_FILE" 2>/dev/null; then
+        run_privileged usermod --add-subuids "$subid_range" "$CURRENT_USER_NAME" || return 2
+    fi
+    if ! grep -q "^${CURRENT_USER_NAME}:" "$ETC_SUBGID_FILE" 2>/dev/null; then
+        run_privileged usermod --add-subgids "$subid_range" "$CURRENT_USER_NAME" || return 3
+    fi
+    return 0
+}
+
+###
+# Install selected container runtime using system's package manager,
+# then configure the current user to be able to use it.
+# If selected runtime is already installed, only the configuration is applied.
+###
+function install_container_runtime {
+    if ! hash "$CONTAINER_RUNTIME" &>/dev/null; then
+        local runtime_package="$CONTAINER_RUNTIME"
+        if [[ "$CONTAINER_RUNTIME" == "docker" ]]; then
+            if ! runtime_package="$(_get_docker_package_name)"; then
+                error "Installing docker with '$PACKAGE_MANAGER' isn't supported, please install it manually"
+                return 1
+            fi
+        fi
+
+        ! _install_packages_with_package_manager "$runtime_package" && return 2
+    fi
+
+    case "$CONTAINER_RUNTIME" in
+    docker)
+        _configure_docker
+        ;;
+    podman)
+        _configure_rootless_podman
+        ;;
+    esac
+}
+
+###
+# Find where brew is installed, even if it isn't in PATH yet.
+# Output (stdout):
+#       Path to the brew executable, or an empty string if not found
+# Returns:
+#       0 if brew has been found, 1 otherwise.
+###
+function _find_brew_path {
+    local brew_location
+    for brew_location in "${BREW_LOCATIONS[@]}"; do
+        if [[ -x "$brew_location" ]]; then
+            echo "$brew_location"
+            return 0
+        fi
+    done
+
+    echo ""
+    return 1
 }
 
 ###
@@ -390,16 +976,26 @@ function install_shell {
 # The script requires some interactivity.
 ###
 function install_brew {
-    if hash brew &>/dev/null || [[ -f "$DEFAULT_BREW_PATH" ]]; then
-        return 0
-    fi
+    hash brew &>/dev/null && return 0
 
-    if ! bash -c "$(curl -fsSL https://raw.githubusercontent.com/Homebrew/install/HEAD/install.sh)"; then
-        return 1
+    local brew_path
+    if ! brew_path="$(_find_brew_path)"; then
+        # The script itself is recorded, as its URL always points to the latest version
+        local brew_install_script
+        brew_install_script="$(curl -fsSL https://raw.githubusercontent.com/Homebrew/install/HEAD/install.sh)" || return 1
+        run_recorded bash -c "$brew_install_script" || return 1
+
+        if ! brew_path="$(_find_brew_path)"; then
+            error "brew isn't found at any of the expected locations after installing it"
+            return 2
+        fi
     fi
 
     # Eval brew for current session to be able to use it later, if needed
-    eval "$($BREW_LOCATION_RESOLVING_CMD)"
+    # New shells get it from the managed shell env instead
+    local brew_env
+    brew_env="$("$brew_path" shellenv)" || return 3
+    eval "$brew_env"
 }
 
 ###
@@ -412,17 +1008,15 @@ function install_dotfiles_manager {
         return 0
     fi
 
-    local installation_failed=false
-
+    local install_script=""
     if [[ "$DOWNLOAD_TOOL" == "curl" ]]; then
-        ! sh -c "$(curl -fsLS git.io/chezmoi)" && installation_failed=true
+        install_script="$(curl -fsLS git.io/chezmoi)" || return 1
     elif [[ "$DOWNLOAD_TOOL" == "wget" ]]; then
-        ! sh -c "$(wget -qO- git.io/chezmoi)" && installation_failed=true
+        install_script="$(wget -qO- git.io/chezmoi)" || return 1
     fi
 
-    if [[ "$installation_failed" == true ]]; then
-        return 1
-    fi
+    # The script itself is recorded, as its URL always points to the latest version
+    run_recorded sh -c "$install_script" || return 2
     return 0
 }
 
@@ -430,6 +1024,19 @@ function install_dotfiles_manager {
 # Install dotfiles. This is the main "driver" function.
 ###
 function install_dotfiles {
+    info "Resolving user identity"
+    if ! resolve_identity; then
+        error "Failed resolving user identity"
+        return 1
+    fi
+    success "Successfully resolved user identity, $FULL_NAME <$ACTIVE_EMAIL>"
+
+    info "Caching privilege credentials"
+    if ! cache_privilege_credentials; then
+        # It's not a fatal error, the user will simply be prompted again when required
+        warning "Failed caching privilege credentials, you might be prompted for them multiple times"
+    fi
+
     info "Installing dotfiles manager ($DOTFILES_MANAGER)"
     if ! install_dotfiles_manager; then
         error "Failed installing dotfiles manager ($DOTFILES_MANAGER)"
@@ -453,6 +1060,15 @@ function install_dotfiles {
     fi
     success "Successfully installed $SHELL_TO_INSTALL"
 
+    if [[ "$INSTALL_CONTAINER_RUNTIME" == true ]]; then
+        info "Installing container runtime ($CONTAINER_RUNTIME)"
+        if ! install_container_runtime; then
+            error "Failed installing container runtime ($CONTAINER_RUNTIME)"
+            return 2
+        fi
+        success "Successfully installed $CONTAINER_RUNTIME"
+    fi
+
     info "Ensuring a GPG key exists"
     if ! ensure_gpg_key_exist; then
         error "Failed ensuring a GPG key exists"
@@ -472,7 +1088,9 @@ function install_dotfiles {
         error "Failed applying dotfiles"
         return 5
     fi
-    success "Successfully applied dotfiles"
+    if [[ "$DOTFILES_APPLIED" == true ]]; then
+        success "Successfully applied dotfiles"
+    fi
 
     info "Finalizing installation"
     if ! post_install; then
@@ -505,17 +1123,92 @@ function get_download_tool {
     return 1
 }
 
+###
+# Checks which privilege escalation tool is locally available from a preset list
+# and outputs the first that has been found, in order of preference.
+###
+function get_escalation_tool {
+    local optional_escalation_tools=(
+        sudo
+        doas
+        run0
+    )
+
+    for escalation_tool in "${optional_escalation_tools[@]}"; do
+        if hash "${escalation_tool}" 2>/dev/null; then
+            echo "${escalation_tool}"
+            return 0
+        fi
+    done
+
+    echo ""
+    return 1
+}
+
+###
+# Start a new transcript file, overwriting any existing one.
+# Commands are appended to it later, as they're executed by "run_recorded".
+###
+function start_transcript {
+    local transcript_dir
+    transcript_dir="$(cd "$(dirname "$TRANSCRIPT_PATH")" && pwd)" || return 1
+    TRANSCRIPT_PATH="$transcript_dir/$(basename "$TRANSCRIPT_PATH")"
+
+    printf "# Dotfiles installation transcript, started at %s\n" "$(date)" >"$TRANSCRIPT_PATH"
+}
+
 ###
 # Set global variables
 ###
 function set_globals {
-    if [[ -n "$INSTALL_REF" ]]; then
-        APPLY_DOTFILES_CMD+=(--branch "$INSTALL_REF")
+    case "$COLOR_MODE" in
+    always) ;;
+    never)
+        disable_colors
+        export NO_COLOR=1
+        ;;
+    auto)
+        # See https://no-color.org
+        if [[ -n "${NO_COLOR:-}" || ! -t 1 ]]; then
+            disable_colors
+        fi
+        ;;
+    *)
+        error "Unsupported color mode: $COLOR_MODE"
+        return 5
+        ;;
+    esac
+    # Let scripts run by the dotfiles manager follow the same color mode
+    export DOTFILES_COLOR_MODE="$COLOR_MODE"
+
+    INIT_DOTFILES_CMD+=("$DOTFILES_REPO")
+
+    # Dotfiles of this repo are taken from the same ref as the installation itself, unless told otherwise
+    # Other repos (forks, mirrors) might not have such a ref, so their default branch is used instead
+    if [[ -z "$DOTFILES_BRANCH" && "$DOTFILES_REPO" == "$GITHUB_USERNAME" ]]; then
+        DOTFILES_BRANCH="$INSTALL_REF"
+    fi
+    if [[ -n "$DOTFILES_BRANCH" ]]; then
+        INIT_DOTFILES_CMD+=(--branch "$DOTFILES_BRANCH")
+    fi
+
+    # Homebrew doesn't support ARM on Linux (e.g. Raspberry Pi), so fall back to system's package manager
+    if [[ "$INSTALL_BREW" == true && "$(uname -s)" == "Linux" ]]; then
+        case "$(uname -m)" in
+        aarch64 | arm64 | armv*)
+            warning "Homebrew isn't supported on ARM Linux, installing tools with system's package manager instead"
+            INSTALL_BREW=false
+            ;;
+        esac
     fi
 
     # Can't prefer to install with brew if brew should not even be installed
     if [[ "$INSTALL_BREW" == false ]]; then
+        if [[ "$INSTALL_SHELL_WITH_BREW" == true ]]; then
+            warning "brew isn't installed, installing shell with system's package manager instead"
+        fi
         PREFER_BREW_FOR_ALL_TOOLS=false
+        INSTALL_SHELL_WITH_BREW=false
     fi
 
     if ! DOWNLOAD_TOOL="$(get_download_tool)"; then
@@ -529,17 +1222,50 @@ function set_globals {
         ROOT_USER=true
     fi
 
+    if [[ -n "$ESCALATION_TOOL" ]]; then
+        case "$ESCALATION_TOOL" in
+        sudo | doas | run0) ;;
+        *)
+            error "Unsupported privilege escalation tool: $ESCALATION_TOOL"
+            return 4
+            ;;
+        esac
+
+        if ! hash "$ESCALATION_TOOL" 2>/dev/null; then
+            error "Privilege escalation tool '$ESCALATION_TOOL' couldn't be found"
+            return 4
+        fi
+    elif ! ESCALATION_TOOL="$(get_escalation_tool)" && [[ "$ROOT_USER" == false ]]; then
+        warning "Couldn't find any privilege escalation tool, steps requiring root privileges will fail"
+    fi
+
+    case "$CONTAINER_RUNTIME" in
+    docker | podman) ;;
+    *)
+        error "Unsupported container runtime: $CONTAINER_RUNTIME"
+        return 3
+        ;;
+    esac
+
+    # Containers on Mac run inside a VM (Docker Desktop, podman machine), which is out of our scope
+    if [[ "$INSTALL_CONTAINER_RUNTIME" == true && "$(uname -s)" == "Darwin" ]]; then
+        error "Installing a container runtime isn't supported on Mac, please install one manually"
+        return 3
+    fi
+
+    if [[ -n "$GPG_PASSPHRASE_FILE" && ! -r "$GPG_PASSPHRASE_FILE" ]]; then
+        error "GPG passphrase file '$GPG_PASSPHRASE_FILE' can't be read"
+        return 6
+    fi
+
     if ! SHELL_USER_PROFILE="$(get_shell_user_profile "$SHELL_TO_INSTALL")"; then
         error "Failed determining shell's user profile"
         return 2
     fi
 
     if [[ "$WORK_ENVIRONMENT" == true ]]; then
-        ACTIVE_EMAIL="$WORK_EMAIL"
         WORK_SPECIFIC_DOTFILES_DIR="${WORK_GENERIC_DOTFILES_DIR}/${WORK_NAME}"
         WORK_SPECIFIC_DOTFILES_PROFILE="${WORK_SPECIFIC_DOTFILES_DIR}/profile"
-    else
-        ACTIVE_EMAIL="$PERSONAL_EMAIL"
     fi
 }
 
@@ -553,12 +1279,16 @@ function parse_arguments {
         return 1
     fi
 
-    local short_options=hv
-    local long_options=help,verbose
-    long_options+=,ref:
+    local short_options=hvy
+    local long_options=help,verbose,yes,color:,transcript:
+    long_options+=,ref:,dotfiles-repo:,dotfiles-branch:
+    long_options+=,full-name:,email:
     long_options+=,work-env,work-name:,work-email:
+    long_options+=,gpg-key-algorithm:,gpg-key-expiry:,gpg-passphrase-file:
     long_options+=,shell:,brew-shell
-    long_options+=,no-brew,prefer-package-manager,package-manager:
+    long_options+=,no-brew,brew-cleanup,prefer-package-manager,package-manager:
+    long_options+=,escalation-tool:
+    long_options+=,containers,container-runtime:
 
     # -temporarily store output to be able to check for errors
     # -activate quoting/enhanced mode (e.g. by writing out “--options”)
@@ -584,10 +1314,38 @@ function parse_arguments {
             VERBOSE=true
             shift
             ;;
+        -y | --yes)
+            ASSUME_YES=true
+            shift
+            ;;
+        --color)
+            COLOR_MODE="${2:-auto}"
+            shift 2
+            ;;
+        --transcript)
+            TRANSCRIPT_PATH="${2:-}"
+            shift 2
+            ;;
         --ref)
             INSTALL_REF="${2:-main}"
             shift 2
             ;;
+        --dotfiles-repo)
+            [ -n "$2" ] && DOTFILES_REPO="${2}"
+            shift 2
+            ;;
+        --dotfiles-branch)
+            DOTFILES_BRANCH="${2:-}"
+            shift 2
+            ;;
+        --full-name)
+            FULL_NAME="${2:-}"
+            shift 2
+            ;;
+        --email)
+            PERSONAL_EMAIL="${2:-}"
+            shift 2
+            ;;
         --work-env)
             WORK_ENVIRONMENT=true
             shift
@@ -602,6 +1360,18 @@ function parse_arguments {
             WORK_ENVIRONMENT=true
             shift 2
             ;;
+        --gpg-key-algorithm)
+            [ -n "$2" ] && GPG_KEY_ALGORITHM="${2}"
+            shift 2
+            ;;
+        --gpg-key-expiry)
+            [ -n "$2" ] && GPG_KEY_EXPIRY="${2}"
+            shift 2
+            ;;
+        --gpg-passphrase-file)
+            GPG_PASSPHRASE_FILE="${2:-}"
+            shift 2
+            ;;
         --shell)
             SHELL_TO_INSTALL="${2:-}"
             shift 2
@@ -614,6 +1384,10 @@ function parse_arguments {
             INSTALL_BREW=false
             shift
             ;;
+        --brew-cleanup)
+            CLEANUP_BREW=true
+            shift
+            ;;
         --prefer-package-manager)
             PREFER_BREW_FOR_ALL_TOOLS=false
             shift
@@ -622,6 +1396,19 @@ function parse_arguments {
             PACKAGE_MANAGER="${2:-}"
             shift 2
             ;;
+        --escalation-tool)
+            ESCALATION_TOOL="${2:-}"
+            shift 2
+            ;;
+        --containers)
+            INSTALL_CONTAINER_RUNTIME=true
+            shift
+            ;;
+        --container-runtime)
+            INSTALL_CONTAINER_RUNTIME=true
+            [ -n "$2" ] && CONTAINER_RUNTIME="${2}"
+            shift 2
+            ;;
         --)
             shift
             break
@@ -644,16 +1431,43 @@ function _set_work_info_defaults() {
 
 function _set_package_management_defaults {
     PACKAGE_MANAGER=""
+    PACKAGE_MANAGER_CACHE_REFRESHED=false
     INSTALL_BREW=true
+    CLEANUP_BREW=false
     PREFER_BREW_FOR_ALL_TOOLS=true
-    DEFAULT_BREW_PATH="/home/linuxbrew/.linuxbrew/bin/brew"
-    BREW_LOCATION_RESOLVING_CMD="$DEFAULT_BREW_PATH shellenv"
+    # Same locations the managed shell env loads brew from
+    BREW_LOCATIONS=(
+        /home/linuxbrew/.linuxbrew/bin/brew
+        "${HOME}/.linuxbrew/bin/brew"
+        /opt/homebrew/bin/brew
+        /usr/local/bin/brew
+    )
+}
+
+function _set_sensitive_dirs_defaults {
+    SENSITIVE_DIRS=("${HOME}/.ssh" "${GNUPGHOME:-${HOME}/.gnupg}")
+}
+
+function _set_gpg_defaults {
+    GPG_KEY_ALGORITHM=ed25519
+    GPG_KEY_EXPIRY=2y
+    GPG_PASSPHRASE_FILE=""
 }
 
 function _set_shell_defaults {
     INSTALL_SHELL_WITH_BREW=false
     SHELL_TO_INSTALL=zsh
     SHELL_USER_PROFILE=""
+    ETC_SHELLS_FILE="/etc/shells"
+}
+
+function _set_container_defaults {
+    INSTALL_CONTAINER_RUNTIME=false
+    CONTAINER_RUNTIME=docker
+    ETC_SUBUID_FILE="/etc/subuid"
+    ETC_SUBGID_FILE="/etc/subgid"
+    SUBID_RANGE_MIN_START=100000
+    SUBID_RANGE_SIZE=65536
 }
 
 function _set_dotfiles_manager_defaults {
@@ -661,23 +1475,27 @@ function _set_dotfiles_manager_defaults {
     DOTFILES_MANAGER_STANDALONE_BINARY_PATH="${HOME}/bin/${DOTFILES_MANAGER}"
     
     if hash "$DOTFILES_MANAGER" &>/dev/null; then
-        APPLY_DOTFILES_CMD=("$(which "$DOTFILES_MANAGER")")
+        DOTFILES_MANAGER_CMD="$(which "$DOTFILES_MANAGER")"
     else
-        APPLY_DOTFILES_CMD=("$DOTFILES_MANAGER_STANDALONE_BINARY_PATH")
+        DOTFILES_MANAGER_CMD="$DOTFILES_MANAGER_STANDALONE_BINARY_PATH"
     fi
 
-    APPLY_DOTFILES_CMD+=(init --apply "$GITHUB_USERNAME")
+    INIT_DOTFILES_CMD=("$DOTFILES_MANAGER_CMD" init)
+    DOTFILES_REPO="$GITHUB_USERNAME"
+    DOTFILES_BRANCH=
 
-    DOTFILES_CLONE_PATH="${HOME}/.local/share/${DOTFILES_MANAGER}"
-    ENVIRONMENT_TEMPLATE_CONFIG_DIR="$HOME/.config/${DOTFILES_MANAGER}"
+    # Resolve the same XDG base directories chezmoi itself uses
+    DOTFILES_CLONE_PATH="${XDG_DATA_HOME:-${HOME}/.local/share}/${DOTFILES_MANAGER}"
+    ENVIRONMENT_TEMPLATE_CONFIG_DIR="${XDG_CONFIG_HOME:-${HOME}/.config}/${DOTFILES_MANAGER}"
     ENVIRONMENT_TEMPLATE_FILE_PATH="${ENVIRONMENT_TEMPLATE_CONFIG_DIR}/${DOTFILES_MANAGER}.toml"
 }
 
 function _set_personal_info_defaults {
     GITHUB_USERNAME="MrPointer"
-    FULL_NAME="Timor Gruber"
-    PERSONAL_EMAIL="timor.gruber@gmail.com"
-    WORK_EMAIL="timor.gruber@solaredge.com"
+    FULL_NAME=""
+    PERSONAL_EMAIL=""
+    WORK_EMAIL=""
+    ACTIVE_EMAIL=""
 }
 
 ###
@@ -685,14 +1503,24 @@ function _set_personal_info_defaults {
 ###
 function set_defaults {
     VERBOSE=false
+    COLOR_MODE=auto
+    TRANSCRIPT_PATH=""
+    ASSUME_YES=false
+    DOTFILES_APPLIED=false
     INSTALL_REF=main
     WORK_ENVIRONMENT=false
     ROOT_USER=false
+    ESCALATION_TOOL=""
+    CREDENTIALS_REFRESH_INTERVAL=60
+    CREDENTIALS_REFRESH_PID=""
 
     _set_personal_info_defaults
     _set_dotfiles_manager_defaults
+    _set_gpg_defaults
+    _set_sensitive_dirs_defaults
     _set_shell_defaults
     _set_package_management_defaults
+    _set_container_defaults
     _set_work_info_defaults
 }
 
@@ -715,6 +1543,11 @@ function main {
         return 1
     fi
 
+    if [[ -n "$TRANSCRIPT_PATH" ]] && ! start_transcript; then
+        error "Failed starting transcript at $TRANSCRIPT_PATH, aborting"
+        return 1
+    fi
+
     info "Installing dotfiles"
     if ! install_dotfiles; then
         error "Failed installing dotfiles"
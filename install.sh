@@ -9,6 +9,39 @@ YELLOW_COLOR="\033[1;33m"
 BLUE_COLOR="\033[0;34m"
 NEUTRAL_COLOR="\033[0m"
 
+###
+# Disable colorful prints by clearing all color codes
+###
+disable_colors() {
+    RED_COLOR=""
+    GREEN_COLOR=""
+    YELLOW_COLOR=""
+    BLUE_COLOR=""
+    NEUTRAL_COLOR=""
+}
+
+###
+# Apply given color mode, one of 'auto', 'always' or 'never'.
+# Must be called before printing anything, as the default is to always colorize.
+###
+apply_color_mode() {
+    case "$1" in
+    always) ;;
+    never)
+        disable_colors
+        ;;
+    auto)
+        # See https://no-color.org
+        if [ -n "${NO_COLOR:-}" ] || [ ! -t 1 ]; then
+            disable_colors
+        fi
+        ;;
+    *)
+        return 1
+        ;;
+    esac
+}
+
 error() {
     printf "${RED_COLOR}%s${NEUTRAL_COLOR}\n" "$@"
 }
@@ -38,7 +71,8 @@ get_download_tool() {
 invoke_actual_installation() {
     # Create temporary executable file to hold the contents
     # of the downloaded implementation script
-    TMP_IMPL_INSTALL_PATH="$(mktemp)"
+    TMP_IMPL_INSTALL_PATH="$INSTALL_TMP_DIR/install-impl.sh"
+    touch "$TMP_IMPL_INSTALL_PATH"
     chmod +x "$TMP_IMPL_INSTALL_PATH"
 
     # Execute manually for every type of download tool to get exit code, it's impossible otherwise...
@@ -68,13 +102,31 @@ invoke_actual_installation() {
     return 0
 }
 
+get_escalation_tool() {
+    if [ "$(id -u)" -eq 0 ]; then
+        echo ""
+    elif [ -n "$ESCALATION_TOOL" ]; then
+        echo "$ESCALATION_TOOL"
+    elif hash sudo 2>/dev/null; then
+        echo "sudo"
+    elif hash doas 2>/dev/null; then
+        echo "doas"
+    elif hash run0 2>/dev/null; then
+        echo "run0"
+    else
+        echo ""
+    fi
+}
+
 install_bash_with_package_manager() {
+    v_escalation_tool="$(get_escalation_tool)"
+
     case "$1" in
     apt)
-        sudo apt install -y bash
+        $v_escalation_tool apt install -y bash
         ;;
     dnf)
-        sudo dnf install -y bash
+        $v_escalation_tool dnf install -y bash
         ;;
     *) ;;
 
@@ -110,6 +162,26 @@ parse_arguments() {
             [ -n "$2" ] && INSTALL_REF="${2}"
             shift 2
             ;;
+        --ref=*)
+            INSTALL_REF="${1#*=}"
+            shift
+            ;;
+        --escalation-tool)
+            [ -n "$2" ] && ESCALATION_TOOL="${2}"
+            shift 2
+            ;;
+        --escalation-tool=*)
+            ESCALATION_TOOL="${1#*=}"
+            shift
+            ;;
+        --color)
+            [ -n "$2" ] && COLOR_MODE="${2}"
+            shift 2
+            ;;
+        --color=*)
+            COLOR_MODE="${1#*=}"
+            shift
+            ;;
         *)
             # Probably options to the real installer (implementation), simply shift past them
             shift
@@ -118,12 +190,25 @@ parse_arguments() {
     done
 }
 
+###
+# Validate parsed arguments, after colors have been set according to them
+###
+validate_arguments() {
+    case "$ESCALATION_TOOL" in
+    "" | sudo | doas | run0) ;;
+    *)
+        error "Unsupported escalation tool '$ESCALATION_TOOL', expected one of: sudo, doas, run0"
+        return 1
+        ;;
+    esac
+}
+
 supported_system() {
     v_system="${1:?}"
     v_distro="${2:?}"
     v_pkg_manager="${3:?}"
 
-    v_supported_distros_file="$(mktemp)" || return 1
+    v_supported_distros_file="$INSTALL_TMP_DIR/supported-distros"
     echo "$SUPPORTED_LINUX_DISTROS" >"$v_supported_distros_file"
 
     if ! grep -q "$v_distro" "$v_supported_distros_file"; then
@@ -133,15 +218,52 @@ supported_system() {
     fi
     unset v_supported_distros_file
 
+    v_minimum_version="$(_get_minimum_distro_version "$v_distro")"
+    if [ -n "$v_minimum_version" ] && [ -n "$DISTRO_VERSION" ] &&
+        _version_lower_than "$DISTRO_VERSION" "$v_minimum_version"; then
+        warning "$v_distro $DISTRO_VERSION is older than the oldest supported version ($v_minimum_version), installation might fail"
+    fi
+    unset v_minimum_version
+
     unset v_system v_distro v_pkg_manager
 }
 
+###
+# Compare two dot-separated versions (up to 3 parts), e.g. "20.04" and "22.04".
+# Succeeds if the first version is lower than the second.
+###
+_version_lower_than() {
+    awk -v first="$1" -v second="$2" 'BEGIN {
+        split(first, f, ".")
+        split(second, s, ".")
+        for (i = 1; i <= 3; i++) {
+            if (f[i] + 0 < s[i] + 0) exit 0
+            if (f[i] + 0 > s[i] + 0) exit 1
+        }
+        exit 1
+    }'
+}
+
+_get_minimum_distro_version() {
+    case "$1" in
+    ubuntu)
+        echo "22.04"
+        ;;
+    debian | raspbian)
+        echo "12"
+        ;;
+    *)
+        echo ""
+        ;;
+    esac
+}
+
 _get_default_system_package_manager() {
     case "$1" in
     mac | darwin)
         echo "brew"
         ;;
-    ubuntu | debian | suse)
+    ubuntu | debian | raspbian | suse)
         echo "apt"
         ;;
     fedora | centos | redhat)
@@ -158,8 +280,9 @@ _get_linux_distro_name() {
 
     if [ -f /etc/os-release ]; then
         # freedesktop.org and systemd
+        # ID is preferred as NAME might be verbose, e.g. "Debian GNU/Linux"
         . /etc/os-release
-        distro=$NAME
+        distro=${ID:-$NAME}
     elif [ -f /etc/lsb-release ]; then
         # For some versions of Debian/Ubuntu without lsb_release command
         . /etc/lsb-release
@@ -181,6 +304,20 @@ _get_linux_distro_name() {
     echo "$distro" | tr '[:upper:]' '[:lower:]'
 }
 
+_get_linux_distro_version() {
+    version=""
+
+    if [ -f /etc/os-release ]; then
+        . /etc/os-release
+        version="${VERSION_ID:-}"
+    elif [ -f /etc/lsb-release ]; then
+        . /etc/lsb-release
+        version="${DISTRIB_RELEASE:-}"
+    fi
+
+    echo "$version"
+}
+
 _get_system_type() {
     case "$(uname -s)" in
     Darwin)
@@ -205,9 +342,11 @@ detect_system() {
             error "Failed detecting linux distribution"
             return 2
         fi
+        DISTRO_VERSION="$(_get_linux_distro_version)"
         ;;
     mac)
         DISTRO_NAME="mac"
+        DISTRO_VERSION="$(sw_vers -productVersion)"
         ;;
     *)
         error "Unsupported system type: $SYSTEM_TYPE"
@@ -230,19 +369,50 @@ detect_system() {
     info "----------------"
     info "Type: $SYSTEM_TYPE"
     info "Distro: $DISTRO_NAME"
+    info "Version: ${DISTRO_VERSION:-unknown}"
     info "Package manager: $PKG_MANAGER"
     printf "\n" # Print an empty line
 }
 
 set_defaults() {
     INSTALL_REF="main"
-    SUPPORTED_LINUX_DISTROS="ubuntu debian"
+    ESCALATION_TOOL=""
+    COLOR_MODE="auto"
+    SUPPORTED_LINUX_DISTROS="ubuntu debian raspbian"
+}
+
+cleanup() {
+    rm -rf "$INSTALL_TMP_DIR"
 }
 
 main() {
+    set_defaults # Should never fail
+
+    # Arguments are parsed before printing anything, as they control colors
+    if ! parse_arguments "$@"; then
+        error "Failed parsing arguments, aborting"
+        return 2
+    fi
+
+    if ! apply_color_mode "$COLOR_MODE"; then
+        disable_colors
+        error "Unsupported color mode: $COLOR_MODE"
+        return 2
+    fi
+
+    if ! validate_arguments; then
+        error "Invalid arguments, aborting"
+        return 2
+    fi
+
     info "Installing dotfiles, but first some bootstrapping"
 
-    set_defaults # Should never fail
+    # Keep all temporary files in a single place, removed when done
+    if ! INSTALL_TMP_DIR="$(mktemp -d)"; then
+        error "Failed creating temporary directory"
+        return 6
+    fi
+    trap cleanup EXIT
 
     info "Detecting system"
     if ! detect_system; then
@@ -255,11 +425,6 @@ main() {
         return 1
     fi
 
-    if ! parse_arguments "$@"; then
-        error "Failed parsing arguments, aborting"
-        return 2
-    fi
-
     info "Installing bash (if required)"
     if ! install_bash "$PKG_MANAGER"; then
         error "Failed installing bash!"